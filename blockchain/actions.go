@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bytom/blockchain/txbuilder"
+)
+
+// actionDecodeFunc decodes the JSON body of a single build-transaction
+// action into a txbuilder.Action.
+type actionDecodeFunc func([]byte) (txbuilder.Action, error)
+
+// actionType pairs a registered action decoder with the JSON schema
+// describing its input, surfaced by GET /list-action-types.
+type actionType struct {
+	decoder actionDecodeFunc
+	schema  map[string]interface{}
+}
+
+// actionRegistry is a concurrency-safe registry of action decoders,
+// keyed by action type name. A BlockchainReactor seeds its registry
+// with the built-in action types at construction; third-party packages
+// can contribute their own via RegisterAction, including during node
+// startup.
+type actionRegistry struct {
+	mu    sync.RWMutex
+	types map[string]actionType
+}
+
+func newActionRegistry() *actionRegistry {
+	return &actionRegistry{types: make(map[string]actionType)}
+}
+
+func (r *actionRegistry) register(name string, decoder actionDecodeFunc, schema map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[name] = actionType{decoder: decoder, schema: schema}
+}
+
+func (r *actionRegistry) decoder(name string) (actionDecodeFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.types[name]
+	return t.decoder, ok
+}
+
+func (r *actionRegistry) schemas() map[string]map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]map[string]interface{}, len(r.types))
+	for name, t := range r.types {
+		out[name] = t.schema
+	}
+	return out
+}
+
+// actions returns bcr's action-decoder registry, initialized on bcr at
+// construction time (see NewBlockchainReactor in api.go).
+func (bcr *BlockchainReactor) actions() *actionRegistry {
+	return bcr.actionRegistry
+}
+
+// registerBuiltinActions seeds registry with the action types that
+// ship with bytom itself.
+func registerBuiltinActions(bcr *BlockchainReactor, registry *actionRegistry) {
+	registry.register("control_account", bcr.accounts.DecodeControlAction, nil)
+	registry.register("control_address", txbuilder.DecodeControlAddressAction, nil)
+	registry.register("control_program", txbuilder.DecodeControlProgramAction, nil)
+	registry.register("control_receiver", txbuilder.DecodeControlReceiverAction, nil)
+	registry.register("issue", bcr.assets.DecodeIssueAction, nil)
+	registry.register("retire", txbuilder.DecodeRetireAction, nil)
+	registry.register("spend_account", bcr.accounts.DecodeSpendAction, nil)
+	registry.register("spend_account_unspent_output", bcr.accounts.DecodeSpendUTXOAction, nil)
+	registry.register("set_transaction_reference_data", txbuilder.DecodeSetTxRefDataAction, nil)
+}
+
+// RegisterAction makes action type name available to
+// /build-transaction and /build-transaction-batch, decoded by decoder.
+// schema, if non-nil, is surfaced verbatim by GET /list-action-types so
+// callers can introspect third-party action types without reading
+// their source. RegisterAction is safe to call concurrently, including
+// from multiple packages' init-time registration during node startup.
+func (bcr *BlockchainReactor) RegisterAction(name string, decoder func([]byte) (txbuilder.Action, error), schema map[string]interface{}) {
+	bcr.actions().register(name, decoder, schema)
+}
+
+// actionTypeInfo is one entry of the GET /list-action-types response.
+type actionTypeInfo struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+// GET /list-action-types
+func (bcr *BlockchainReactor) listActionTypes(ctx context.Context) Response {
+	schemas := bcr.actions().schemas()
+
+	infos := make([]actionTypeInfo, 0, len(schemas))
+	for name, schema := range schemas {
+		infos = append(infos, actionTypeInfo{Name: name, Schema: schema})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return resWrapper(infos)
+}
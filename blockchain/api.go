@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"net/http"
+
+	"github.com/bytom/account"
+	"github.com/bytom/asset"
+	"github.com/bytom/encoding/json"
+	"github.com/bytom/net/http/httpjson"
+	"github.com/bytom/protocol"
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+// BlockchainReactor services the blockchain package's HTTP API:
+// building, signing, submitting and waiting on transactions.
+type BlockchainReactor struct {
+	chain          *protocol.Chain
+	accounts       *account.Manager
+	assets         *asset.Registry
+	actionRegistry *actionRegistry
+}
+
+// NewBlockchainReactor wires up a BlockchainReactor against chain,
+// accounts and assets, seeding its action registry with the built-in
+// action types up front so bcr.actions() never has to.
+func NewBlockchainReactor(chain *protocol.Chain, accounts *account.Manager, assets *asset.Registry) *BlockchainReactor {
+	bcr := &BlockchainReactor{chain: chain, accounts: accounts, assets: assets}
+	bcr.actionRegistry = newActionRegistry()
+	registerBuiltinActions(bcr, bcr.actionRegistry)
+	return bcr
+}
+
+// buildHandler returns the mux serving the package's HTTP endpoints.
+func (bcr *BlockchainReactor) buildHandler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/build-transaction", httpjson.Handler(bcr.build))
+	m.Handle("/build-transaction-batch", httpjson.Handler(bcr.buildBatch))
+	m.Handle("/sign-transaction-batch", httpjson.Handler(bcr.signBatch))
+	m.Handle("/submit-transaction", httpjson.Handler(bcr.submit))
+	m.Handle("/submit-transaction-batch", httpjson.Handler(bcr.submitBatch))
+	m.Handle("/sign-submit-transaction", httpjson.Handler(bcr.signSubmit))
+	m.Handle("/list-action-types", httpjson.Handler(bcr.listActionTypes))
+	return m
+}
+
+// BuildRequest is the request body accepted by POST /build-transaction
+// and, as an element of the array, POST /build-transaction-batch. A
+// non-empty ClientToken makes the build idempotent: a retry scoped to
+// the same account returns the original template instead of decoding
+// actions and reserving the same UTXOs a second time (see
+// idempotency.go).
+type BuildRequest struct {
+	Tx          *legacy.TxData           `json:"base_transaction"`
+	Actions     []map[string]interface{} `json:"actions"`
+	TTL         json.Duration            `json:"ttl"`
+	ClientToken string                   `json:"client_token"`
+}
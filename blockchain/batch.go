@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bytom/blockchain/txbuilder"
+	"github.com/bytom/net/http/httperror"
+	"github.com/bytom/net/http/reqid"
+)
+
+// maxBatchParallelism bounds how many entries of a
+// build/sign/submit-transaction-batch request run concurrently, so one
+// oversized batch can't starve the reactor's other work.
+const maxBatchParallelism = 8
+
+// batchResponseItem is the per-entry result of a *-transaction-batch
+// endpoint: exactly one of Response or Error is set, at the same index
+// as the corresponding request entry.
+type batchResponseItem struct {
+	Response interface{}         `json:"response,omitempty"`
+	Error    *httperror.Response `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Responses []batchResponseItem `json:"responses"`
+}
+
+// runBatch calls f(i) for i in [0,n), running up to
+// maxBatchParallelism of them concurrently, and blocks until every
+// call has returned.
+func runBatch(n int, f func(i int)) {
+	sem := make(chan struct{}, maxBatchParallelism)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// POST /build-transaction-batch
+func (bcr *BlockchainReactor) buildBatch(ctx context.Context, buildReqs []*BuildRequest) Response {
+	subctx := reqid.NewSubContext(ctx, reqid.New())
+
+	responses := make([]batchResponseItem, len(buildReqs))
+	runBatch(len(buildReqs), func(i int) {
+		itemCtx := reqid.NewSubContext(subctx, reqid.New())
+		tmpl, err := bcr.buildSingle(itemCtx, buildReqs[i])
+		if err != nil {
+			resp := errorFormatter.Format(err)
+			responses[i] = batchResponseItem{Error: &resp}
+			return
+		}
+		responses[i] = batchResponseItem{Response: tmpl}
+	})
+
+	return resWrapper(batchResponse{Responses: responses})
+}
+
+// POST /sign-transaction-batch
+func (bcr *BlockchainReactor) signBatch(ctx context.Context, x struct {
+	Auth         string                `json:"auth"`
+	Transactions []*txbuilder.Template `json:"transactions"`
+}) Response {
+
+	responses := make([]batchResponseItem, len(x.Transactions))
+	runBatch(len(x.Transactions), func(i int) {
+		tpl := x.Transactions[i]
+		if err := txbuilder.Sign(ctx, tpl, nil, x.Auth, bcr.pseudohsmSignTemplate); err != nil {
+			resp := errorFormatter.Format(err)
+			responses[i] = batchResponseItem{Error: &resp}
+			return
+		}
+		responses[i] = batchResponseItem{Response: tpl}
+	})
+
+	return resWrapper(batchResponse{Responses: responses})
+}
+
+// submitBatchRequest mirrors submitTxRequest but for a batch: each
+// entry carries its own template and its own wait_until.
+type submitBatchRequest struct {
+	Transactions []submitTxRequest `json:"transactions"`
+}
+
+// POST /submit-transaction-batch
+//
+// KNOWN LIMITATION, tracked separately as follow-up chunk0-2-followup-1
+// and explicitly NOT part of what this endpoint delivers: the request
+// asked for submissions to coalesce mempool locking so N submissions
+// don't serialize behind N separate locks. That needs a batch-aware
+// FinalizeTx on the chain side that inserts the whole batch under one
+// lock acquisition, which doesn't exist yet, so it isn't attempted
+// here. What this endpoint actually does — running entries
+// concurrently, bounded by maxBatchParallelism — only lets them queue
+// on the chain's existing per-tx pool lock together instead of
+// serializing behind N separate submit-transaction round trips. That's
+// a reasonable interim, but it should not be read as having closed out
+// the locking request.
+func (bcr *BlockchainReactor) submitBatch(ctx context.Context, x submitBatchRequest) Response {
+	responses := make([]batchResponseItem, len(x.Transactions))
+	runBatch(len(x.Transactions), func(i int) {
+		req := x.Transactions[i]
+		waitUntil := req.WaitUntil
+		if waitUntil == "" {
+			waitUntil = waitUntilNone
+		}
+
+		resp, err := bcr.submitSingle(ctx, req.Template, waitUntil, req.ClientToken)
+		if err != nil {
+			log.WithField("err", err).Error("submit single tx")
+			fmtErr := errorFormatter.Format(err)
+			responses[i] = batchResponseItem{Error: &fmtErr}
+			return
+		}
+		responses[i] = batchResponseItem{Response: resp}
+	})
+
+	return resWrapper(batchResponse{Responses: responses})
+}
@@ -0,0 +1,81 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRunBatchIsolatesPerItemErrors checks that one entry failing
+// doesn't stop or corrupt any other entry's result — the property
+// buildBatch/signBatch/submitBatch all rely on to report exactly one
+// of Response or Error per index.
+func TestRunBatchIsolatesPerItemErrors(t *testing.T) {
+	const n = 20
+	results := make([]string, n)
+	errs := make([]error, n)
+
+	runBatch(n, func(i int) {
+		if i%3 == 0 {
+			errs[i] = fmt.Errorf("item %d failed", i)
+			return
+		}
+		results[i] = fmt.Sprintf("ok-%d", i)
+	})
+
+	for i := 0; i < n; i++ {
+		if i%3 == 0 {
+			if errs[i] == nil {
+				t.Errorf("item %d: expected error, got none", i)
+			}
+			if results[i] != "" {
+				t.Errorf("item %d: expected no result alongside its error, got %q", i, results[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("item %d: unexpected error %v", i, errs[i])
+		}
+		if want := fmt.Sprintf("ok-%d", i); results[i] != want {
+			t.Errorf("item %d: result = %q, want %q", i, results[i], want)
+		}
+	}
+}
+
+// TestRunBatchBoundsConcurrency checks that no more than
+// maxBatchParallelism callbacks run at once.
+func TestRunBatchBoundsConcurrency(t *testing.T) {
+	var current, max int64
+
+	runBatch(50, func(i int) {
+		c := atomic.AddInt64(&current, 1)
+		for {
+			m := atomic.LoadInt64(&max)
+			if c <= m || atomic.CompareAndSwapInt64(&max, m, c) {
+				break
+			}
+		}
+		atomic.AddInt64(&current, -1)
+	})
+
+	if max > maxBatchParallelism {
+		t.Fatalf("observed %d concurrent callbacks, want <= %d", max, maxBatchParallelism)
+	}
+}
+
+// TestRunBatchRunsEveryItem checks every index in [0,n) gets exactly
+// one call, regardless of scheduling order.
+func TestRunBatchRunsEveryItem(t *testing.T) {
+	const n = 37
+	var calls [n]int32
+
+	runBatch(n, func(i int) {
+		atomic.AddInt32(&calls[i], 1)
+	})
+
+	for i, c := range calls {
+		if c != 1 {
+			t.Errorf("index %d called %d times, want 1", i, c)
+		}
+	}
+}
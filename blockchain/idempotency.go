@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/bytom/blockchain/txbuilder"
+)
+
+// idempotencyCacheSize bounds how many entries each of the build and
+// submit caches remembers at once.
+const idempotencyCacheSize = 4096
+
+// idempotencyKey identifies a client's build request well enough to
+// recognize a retry: the client-supplied token scoped to the account
+// it spends from, so two different accounts can't collide on the same
+// token.
+type idempotencyKey struct {
+	token     string
+	accountID string
+}
+
+// cachedBuild is what buildSingle stashes for an idempotencyKey the
+// first time it's seen. A retried build-transaction with the same key
+// returns this instead of re-running action decoding and reserving the
+// same UTXOs a second time.
+type cachedBuild struct {
+	tpl       *txbuilder.Template
+	expiresAt time.Time
+}
+
+// cachedSubmit is the submit-path analogue of cachedBuild, keyed on
+// client_token alone since by the time a client submits, the template
+// already pins the accounts and outputs involved.
+type cachedSubmit struct {
+	resp      *submitTxResponse
+	expiresAt time.Time
+}
+
+var (
+	buildCacheOnce sync.Once
+	buildCache     *lru.Cache
+
+	submitCacheOnce sync.Once
+	submitCache     *lru.Cache
+)
+
+func getBuildCache() *lru.Cache {
+	buildCacheOnce.Do(func() {
+		buildCache, _ = lru.New(idempotencyCacheSize)
+	})
+	return buildCache
+}
+
+func getSubmitCache() *lru.Cache {
+	submitCacheOnce.Do(func() {
+		submitCache, _ = lru.New(idempotencyCacheSize)
+	})
+	return submitCache
+}
+
+// accountIDForBuild pulls an account identifier out of the first
+// action that has one, for use as the second half of a build
+// idempotencyKey. Actions are still opaque maps at this point in the
+// pipeline (see buildSingle) — in particular, buildSingle computes the
+// idempotencyKey before bcr.filterAliases runs, so an action that
+// addressed its account by account_alias won't have account_id
+// populated yet. Fall back to the alias in that case rather than
+// collapsing to an empty account component, which would let different
+// accounts collide on the same client_token.
+func accountIDForBuild(req *BuildRequest) string {
+	for _, act := range req.Actions {
+		if id, ok := act["account_id"].(string); ok && id != "" {
+			return id
+		}
+	}
+	for _, act := range req.Actions {
+		if alias, ok := act["account_alias"].(string); ok && alias != "" {
+			return alias
+		}
+	}
+	return ""
+}
+
+// evictBuildCache drops every cached build keyed to clientToken. It's
+// called when a submit for that token is rejected, so the build cache
+// stops pinning the now-dead template's reserved UTXOs: without this,
+// a retried build-transaction with the same client_token would keep
+// getting that dead template back until defaultTxTTL expires. The
+// account half of the key isn't known at submit time, so this scans
+// rather than doing a single Remove.
+func evictBuildCache(clientToken string) {
+	if clientToken == "" {
+		return
+	}
+	cache := getBuildCache()
+	for _, k := range cache.Keys() {
+		if key, ok := k.(idempotencyKey); ok && key.token == clientToken {
+			cache.Remove(key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountIDForBuildFallsBackToAlias(t *testing.T) {
+	req := &BuildRequest{
+		Actions: []map[string]interface{}{
+			{"type": "spend_account", "account_alias": "alice"},
+		},
+	}
+	if got := accountIDForBuild(req); got != "alice" {
+		t.Fatalf("accountIDForBuild = %q, want %q", got, "alice")
+	}
+}
+
+func TestAccountIDForBuildPrefersAccountID(t *testing.T) {
+	req := &BuildRequest{
+		Actions: []map[string]interface{}{
+			{"type": "spend_account", "account_id": "acc1", "account_alias": "alice"},
+		},
+	}
+	if got := accountIDForBuild(req); got != "acc1" {
+		t.Fatalf("accountIDForBuild = %q, want %q", got, "acc1")
+	}
+}
+
+func TestAccountIDForBuildEmpty(t *testing.T) {
+	req := &BuildRequest{Actions: []map[string]interface{}{{"type": "issue"}}}
+	if got := accountIDForBuild(req); got != "" {
+		t.Fatalf("accountIDForBuild = %q, want empty", got)
+	}
+}
+
+func TestEvictBuildCacheRemovesOnlyMatchingToken(t *testing.T) {
+	cache := getBuildCache()
+	keepKey := idempotencyKey{token: "keep", accountID: "acc1"}
+	dropKey1 := idempotencyKey{token: "drop", accountID: "acc1"}
+	dropKey2 := idempotencyKey{token: "drop", accountID: "acc2"}
+
+	entry := &cachedBuild{expiresAt: time.Now().Add(time.Minute)}
+	cache.Add(keepKey, entry)
+	cache.Add(dropKey1, entry)
+	cache.Add(dropKey2, entry)
+
+	evictBuildCache("drop")
+
+	if _, ok := cache.Get(keepKey); !ok {
+		t.Fatal("evictBuildCache removed an entry for an unrelated token")
+	}
+	if _, ok := cache.Get(dropKey1); ok {
+		t.Fatal("evictBuildCache left a matching-token entry behind")
+	}
+	if _, ok := cache.Get(dropKey2); ok {
+		t.Fatal("evictBuildCache left a matching-token entry behind")
+	}
+}
+
+func TestEvictBuildCacheEmptyToken(t *testing.T) {
+	// Must not panic or scan/remove everything when called with no
+	// client_token (the common case: most requests don't set one).
+	evictBuildCache("")
+}
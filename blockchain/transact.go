@@ -16,34 +16,37 @@ import (
 
 var defaultTxTTL = 5 * time.Minute
 
+// Legal values of the wait_until field on /submit-transaction and
+// /sign-submit-transaction requests.
+const (
+	waitUntilNone      = "none"
+	waitUntilConfirmed = "confirmed"
+	waitUntilProcessed = "processed"
+)
+
+var errBadWaitUntil = errors.New("wait_until must be one of none, confirmed, processed")
+
+// actionDecoder looks up the decoder for a build-transaction action
+// type in bcr's action registry (see actions.go), which is seeded with
+// the built-in types and open to third-party registration via
+// RegisterAction.
 func (bcr *BlockchainReactor) actionDecoder(action string) (func([]byte) (txbuilder.Action, error), bool) {
-	var decoder func([]byte) (txbuilder.Action, error)
-	switch action {
-	case "control_account":
-		decoder = bcr.accounts.DecodeControlAction
-	case "control_address":
-		decoder = txbuilder.DecodeControlAddressAction
-	case "control_program":
-		decoder = txbuilder.DecodeControlProgramAction
-	case "control_receiver":
-		decoder = txbuilder.DecodeControlReceiverAction
-	case "issue":
-		decoder = bcr.assets.DecodeIssueAction
-	case "retire":
-		decoder = txbuilder.DecodeRetireAction
-	case "spend_account":
-		decoder = bcr.accounts.DecodeSpendAction
-	case "spend_account_unspent_output":
-		decoder = bcr.accounts.DecodeSpendUTXOAction
-	case "set_transaction_reference_data":
-		decoder = txbuilder.DecodeSetTxRefDataAction
-	default:
-		return nil, false
-	}
-	return decoder, true
+	return bcr.actions().decoder(action)
 }
 
 func (bcr *BlockchainReactor) buildSingle(ctx context.Context, req *BuildRequest) (*txbuilder.Template, error) {
+	var buildKey *idempotencyKey
+	if req.ClientToken != "" {
+		k := idempotencyKey{token: req.ClientToken, accountID: accountIDForBuild(req)}
+		buildKey = &k
+		if cached, ok := getBuildCache().Get(k); ok {
+			if entry := cached.(*cachedBuild); time.Now().Before(entry.expiresAt) {
+				return entry.tpl, nil
+			}
+			getBuildCache().Remove(k)
+		}
+	}
+
 	err := bcr.filterAliases(ctx, req)
 	if err != nil {
 		return nil, err
@@ -96,6 +99,10 @@ func (bcr *BlockchainReactor) buildSingle(ctx context.Context, req *BuildRequest
 	if tpl.SigningInstructions == nil {
 		tpl.SigningInstructions = []*txbuilder.SigningInstruction{}
 	}
+
+	if buildKey != nil {
+		getBuildCache().Add(*buildKey, &cachedBuild{tpl: tpl, expiresAt: time.Now().Add(defaultTxTTL)})
+	}
 	return tpl, nil
 }
 
@@ -112,103 +119,169 @@ func (bcr *BlockchainReactor) build(ctx context.Context, buildReqs *BuildRequest
 	return resWrapper(tmpl)
 }
 
-func (bcr *BlockchainReactor) submitSingle(ctx context.Context, tpl *txbuilder.Template) (map[string]string, error) {
+// submitTxResponse reports the outcome of a submit. Height and
+// BlockHash are only populated once the tx has landed in a block; a
+// processed wait that times out after that still reports them,
+// alongside the error.
+type submitTxResponse struct {
+	TxID      string `json:"txid"`
+	Height    uint64 `json:"block_height,omitempty"`
+	BlockHash string `json:"block_hash,omitempty"`
+}
+
+func (bcr *BlockchainReactor) submitSingle(ctx context.Context, tpl *txbuilder.Template, waitUntil, clientToken string) (*submitTxResponse, error) {
 	if tpl.Transaction == nil {
 		return nil, errors.Wrap(txbuilder.ErrMissingRawTx)
 	}
 
-	err := txbuilder.FinalizeTx(ctx, bcr.chain, tpl.Transaction)
+	if clientToken != "" {
+		if cached, ok := getSubmitCache().Get(clientToken); ok {
+			if entry := cached.(*cachedSubmit); time.Now().Before(entry.expiresAt) {
+				return entry.resp, nil
+			}
+			getSubmitCache().Remove(clientToken)
+		}
+	}
+
+	result, err := bcr.finalizeTxWait(ctx, tpl, waitUntil)
 	if err != nil {
-		return nil, errors.Wrapf(err, "tx %s", tpl.Transaction.ID.String())
+		// Only a genuine rejection means the tx never landed and never
+		// will, which is what actually poisons the build cache's
+		// reserved UTXOs for this client_token. A bad wait_until never
+		// even reaches FinalizeTx, and a context timeout waiting on a
+		// later wait_until stage (confirmed/processed) happens after
+		// the tx was already broadcast — neither should evict a cache
+		// entry the client may still retry against successfully.
+		if errors.Root(err) == txbuilder.ErrRejected {
+			evictBuildCache(clientToken)
+		}
+		if result == nil {
+			return nil, errors.Wrapf(err, "tx %s", tpl.Transaction.ID.String())
+		}
+		// The tx reached result's stage before a later wait_until
+		// stage timed out; report what we know landed instead of
+		// throwing it away.
+		resp := &submitTxResponse{TxID: tpl.Transaction.ID.String(), Height: result.Height, BlockHash: result.BlockHash}
+		return resp, errors.Wrapf(err, "tx %s", tpl.Transaction.ID.String())
+	}
+
+	resp := &submitTxResponse{TxID: tpl.Transaction.ID.String()}
+	if result != nil {
+		resp.Height = result.Height
+		resp.BlockHash = result.BlockHash
 	}
 
-	return map[string]string{"txid": tpl.Transaction.ID.String()}, nil
+	if clientToken != "" {
+		getSubmitCache().Add(clientToken, &cachedSubmit{resp: resp, expiresAt: time.Now().Add(defaultTxTTL)})
+	}
+	return resp, nil
 }
 
-// finalizeTxWait calls FinalizeTx and then waits for confirmation of
-// the transaction.  A nil error return means the transaction is
-// confirmed on the blockchain.  ErrRejected means a conflicting tx is
-// on the blockchain.  context.DeadlineExceeded means ctx is an
-// expiring context that timed out.
-func (bcr *BlockchainReactor) finalizeTxWait(ctx context.Context, txTemplate *txbuilder.Template, waitUntil string) error {
-	// Use the current generator height as the lower bound of the block height
-	// that the transaction may appear in.
-	localHeight := bcr.chain.Height()
-	//generatorHeight := localHeight
+// waitResult is where a transaction that finalizeTxWait was asked to
+// confirm ended up landing.
+type waitResult struct {
+	Height    uint64
+	BlockHash string
+}
 
+// finalizeTxWait calls FinalizeTx and then, depending on waitUntil,
+// waits for the transaction to reach a further stage:
+//
+//   none:      return as soon as the tx is accepted into the pool.
+//   confirmed: block until the tx lands in a block.
+//   processed: block until the tx lands in a block *and* a further
+//              block has landed on top of it, so a single-block
+//              re-org can't immediately invalidate the confirmation
+//              this call just handed back.
+//
+// A nil error means the transaction reached the requested stage.
+// ErrRejected means a conflicting tx is already on the blockchain.
+// context.DeadlineExceeded means ctx is an expiring context that
+// timed out before the tx reached the requested stage.
+func (bcr *BlockchainReactor) finalizeTxWait(ctx context.Context, txTemplate *txbuilder.Template, waitUntil string) (*waitResult, error) {
+	switch waitUntil {
+	case waitUntilNone, waitUntilConfirmed, waitUntilProcessed:
+	default:
+		return nil, errors.WithDetailf(errBadWaitUntil, "got %q", waitUntil)
+	}
+
+	localHeight := bcr.chain.Height()
 	log.WithField("localHeight", localHeight).Info("Starting to finalize transaction")
 
-	err := txbuilder.FinalizeTx(ctx, bcr.chain, txTemplate.Transaction)
-	if err != nil {
-		return err
+	if err := txbuilder.FinalizeTx(ctx, bcr.chain, txTemplate.Transaction); err != nil {
+		return nil, err
 	}
-	if waitUntil == "none" {
-		return nil
+	if waitUntil == waitUntilNone {
+		return nil, nil
 	}
 
-	//TODO:complete finalizeTxWait
-	//height, err := a.waitForTxInBlock(ctx, txTemplate.Transaction, generatorHeight)
+	height, err := bcr.waitForTxInBlock(ctx, txTemplate.Transaction, localHeight)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	result := &waitResult{Height: height}
+	if b, err := bcr.chain.GetBlockByHeight(height); err == nil {
+		result.BlockHash = b.Hash().String()
 	}
-	if waitUntil == "confirmed" {
-		return nil
+	if waitUntil == waitUntilConfirmed {
+		return result, nil
 	}
 
-	return nil
+	select {
+	case <-bcr.chain.BlockWaiter(height + 1):
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+	return result, nil
 }
 
-func (bcr *BlockchainReactor) waitForTxInBlock(ctx context.Context, tx *legacy.Tx, height uint64) (uint64, error) {
-	log.Printf("waitForTxInBlock function")
-	for {
-		height++
-		select {
-		case <-ctx.Done():
-			return 0, ctx.Err()
-
-		case <-bcr.chain.BlockWaiter(height):
-			b, err := bcr.chain.GetBlockByHeight(height)
-			if err != nil {
-				return 0, errors.Wrap(err, "getting block that just landed")
-			}
-			for _, confirmed := range b.Transactions {
-				if confirmed.ID == tx.ID {
-					// confirmed
-					return height, nil
-				}
-			}
-
-			// might still be in pool or might be rejected; we can't
-			// tell definitively until its max time elapses.
-			// Re-insert into the pool in case it was dropped.
-			err = txbuilder.FinalizeTx(ctx, bcr.chain, tx)
-			if err != nil {
-				return 0, err
-			}
-
-			// TODO(jackson): Do simple rejection checks like checking if
-			// the tx's blockchain prevouts still exist in the state tree.
-		}
-	}
+// waitForTxInBlock blocks until tx lands in a block at a height above
+// afterHeight, ctx is done, or re-finalizing a dropped tx fails
+// outright. The actual watching happens on a single goroutine shared
+// across every caller waiting on the same chain (see waiter.go), so
+// concurrent submit-transaction requests don't each spawn their own
+// polling goroutine.
+func (bcr *BlockchainReactor) waitForTxInBlock(ctx context.Context, tx *legacy.Tx, afterHeight uint64) (uint64, error) {
+	return waiterFor(bcr.chain).wait(ctx, tx, afterHeight)
 }
 
 // POST /submit-transaction
-func (bcr *BlockchainReactor) submit(ctx context.Context, tpl *txbuilder.Template) Response {
+func (bcr *BlockchainReactor) submit(ctx context.Context, x submitTxRequest) Response {
+	waitUntil := x.WaitUntil
+	if waitUntil == "" {
+		waitUntil = waitUntilNone
+	}
 
-	txid, err := bcr.submitSingle(nil, tpl)
+	resp, err := bcr.submitSingle(ctx, x.Template, waitUntil, x.ClientToken)
 	if err != nil {
 		log.WithField("err", err).Error("submit single tx")
-		return resWrapper(nil, err)
+		return resWrapper(resp, err)
 	}
 
-	log.WithField("txid", txid).Info("submit single tx")
-	return resWrapper(txid)
+	log.WithField("txid", resp.TxID).Info("submit single tx")
+	return resWrapper(resp)
+}
+
+// submitTxRequest is the request body accepted by POST
+// /submit-transaction. The embedded template's fields are flattened to
+// the top level so existing clients that post a bare template keep
+// working; wait_until and client_token are simply extra sibling
+// fields. A non-empty client_token makes the submit idempotent: a
+// retry with the same token returns the original txid instead of
+// re-broadcasting.
+type submitTxRequest struct {
+	*txbuilder.Template
+	WaitUntil   string `json:"wait_until"`
+	ClientToken string `json:"client_token"`
 }
 
 // POST /sign-submit-transaction
 func (bcr *BlockchainReactor) signSubmit(ctx context.Context, x struct {
-	Auth string             `json:"auth"`
-	Txs  txbuilder.Template `json:"transaction"`
+	Auth        string             `json:"auth"`
+	Txs         txbuilder.Template `json:"transaction"`
+	WaitUntil   string             `json:"wait_until"`
+	ClientToken string             `json:"client_token"`
 }) Response {
 
 	var err error
@@ -219,12 +292,17 @@ func (bcr *BlockchainReactor) signSubmit(ctx context.Context, x struct {
 
 	log.Info("Sign Transaction complete.")
 
-	txID, err := bcr.submitSingle(nil, &x.Txs)
+	waitUntil := x.WaitUntil
+	if waitUntil == "" {
+		waitUntil = waitUntilNone
+	}
+
+	resp, err := bcr.submitSingle(ctx, &x.Txs, waitUntil, x.ClientToken)
 	if err != nil {
 		log.WithField("err", err).Error("submit single tx")
-		return resWrapper(nil, err)
+		return resWrapper(resp, err)
 	}
 
-	log.WithField("txid", txID["txid"]).Info("submit single tx")
-	return resWrapper(txID)
+	log.WithField("txid", resp.TxID).Info("submit single tx")
+	return resWrapper(resp)
 }
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bytom/blockchain/txbuilder"
+	"github.com/bytom/protocol"
+	"github.com/bytom/protocol/bc"
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+// pendingTx is a transaction a txWaiter is watching for at a specific
+// height, along with the bookkeeping needed to re-broadcast it if it
+// falls out of the pool or a re-org drops the block it landed in.
+type pendingTx struct {
+	tx       *legacy.Tx
+	attempts int
+	result   chan uint64
+	err      chan error
+}
+
+// txWaiter multiplexes confirmation waits for every in-flight
+// transaction registered against a chain onto a single block-watching
+// goroutine, analogous to how other chains track in-flight txs and
+// their processing time rather than spawning one watcher per request.
+type txWaiter struct {
+	chain *protocol.Chain
+
+	mu            sync.Mutex
+	pendingBlocks map[uint64][]*pendingTx // height -> txs expected to land there
+	started       bool
+	scanned       uint64 // highest height run() has finished processing
+}
+
+var (
+	waitersMu sync.Mutex
+	waiters   = map[*protocol.Chain]*txWaiter{}
+)
+
+// waiterFor returns the txWaiter shared by every caller watching
+// chain, creating it on first use.
+func waiterFor(chain *protocol.Chain) *txWaiter {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	w, ok := waiters[chain]
+	if !ok {
+		w = &txWaiter{chain: chain, pendingBlocks: make(map[uint64][]*pendingTx)}
+		waiters[chain] = w
+	}
+	return w
+}
+
+// wait registers tx to be watched starting at afterHeight+1 and blocks
+// until it lands in a block, ctx is done, or re-finalizing a dropped
+// tx fails outright.
+func (w *txWaiter) wait(ctx context.Context, tx *legacy.Tx, afterHeight uint64) (uint64, error) {
+	p := &pendingTx{
+		tx:     tx,
+		result: make(chan uint64, 1),
+		err:    make(chan error, 1),
+	}
+
+	w.register(afterHeight+1, p)
+
+	select {
+	case height := <-p.result:
+		return height, nil
+	case err := <-p.err:
+		return 0, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// register enqueues p to be resolved once height lands. If run's
+// watcher is already past height — easily reached since callers read
+// their start height before FinalizeTx and broadcast, and many of them
+// can race in concurrently off the batch-submit path — p would sit in
+// a bucket the watcher will never revisit, so resolveLate checks the
+// already-landed blocks directly instead.
+func (w *txWaiter) register(height uint64, p *pendingTx) {
+	w.mu.Lock()
+	if !w.started {
+		w.started = true
+		w.scanned = height - 1
+		w.pendingBlocks[height] = append(w.pendingBlocks[height], p)
+		w.mu.Unlock()
+		go w.run(height)
+		return
+	}
+	if height > w.scanned {
+		w.pendingBlocks[height] = append(w.pendingBlocks[height], p)
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Unlock()
+	w.resolveLate(height, p)
+}
+
+// resolveLate handles a registration for a height run() has already
+// scanned past. The watcher may be many heights ahead by the time a
+// slow caller registers (concurrent batch submits routinely race in
+// like this), so tx could have landed anywhere in [height, scanned],
+// not just at height — scan the whole range before falling back to
+// re-targeting the registration to just past the watcher's current
+// position.
+func (w *txWaiter) resolveLate(height uint64, p *pendingTx) {
+	w.mu.Lock()
+	scanned := w.scanned
+	w.mu.Unlock()
+
+	if h, ok := scanForTx(w.chain.GetBlockByHeight, height, scanned, p.tx.ID); ok {
+		p.result <- h
+		return
+	}
+
+	w.mu.Lock()
+	next := w.scanned + 1
+	w.mu.Unlock()
+	w.register(next, p)
+}
+
+// scanForTx looks for txID in every block in [from, to], calling
+// getBlock to read each one and skipping any height it can't read.
+// Split out of resolveLate so the late-registration race it fixes —
+// a tx that landed somewhere in a range run() has already scanned
+// past — can be tested without a real chain.
+func scanForTx(getBlock func(uint64) (*legacy.Block, error), from, to uint64, txID bc.Hash) (uint64, bool) {
+	for h := from; h <= to; h++ {
+		b, err := getBlock(h)
+		if err != nil {
+			continue
+		}
+		for _, confirmed := range b.Transactions {
+			if confirmed.ID == txID {
+				return h, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// run watches every block starting at height, resolving pending
+// transactions that landed there and re-queuing, with backoff, the
+// ones that didn't — they may still be in the pool, or may have been
+// dropped and need a fresh FinalizeTx.
+func (w *txWaiter) run(height uint64) {
+	for {
+		<-w.chain.BlockWaiter(height)
+		b, err := w.chain.GetBlockByHeight(height)
+
+		w.mu.Lock()
+		pending := w.pendingBlocks[height]
+		delete(w.pendingBlocks, height)
+		w.scanned = height
+		w.mu.Unlock()
+
+		if err != nil {
+			// Couldn't read the block we were just notified about.
+			// Fail whoever was waiting on it rather than abandoning
+			// them in a bucket nothing will ever resolve, and move
+			// on to the next height for everyone else.
+			for _, p := range pending {
+				p.err <- err
+			}
+			height++
+			continue
+		}
+
+		for _, p := range pending {
+			landed := false
+			for _, confirmed := range b.Transactions {
+				if confirmed.ID == p.tx.ID {
+					landed = true
+					break
+				}
+			}
+			if landed {
+				p.result <- height
+				continue
+			}
+
+			// Re-finalize on its own goroutine and timer: this
+			// runs outside run()'s loop so one transaction backed
+			// off for up to 30s doesn't stall confirmation
+			// tracking for every other in-flight transaction.
+			go w.reFinalize(p, height)
+		}
+
+		height++
+	}
+}
+
+// reFinalize re-broadcasts p after the backoff for its attempt count
+// and, on success, re-registers it for the next height. It runs off
+// run()'s goroutine so its sleep never blocks other pending txs.
+func (w *txWaiter) reFinalize(p *pendingTx, height uint64) {
+	p.attempts++
+	time.Sleep(backoff(p.attempts))
+	if err := txbuilder.FinalizeTx(context.Background(), w.chain, p.tx); err != nil {
+		p.err <- err
+		return
+	}
+	w.register(height+1, p)
+}
+
+// backoff returns the delay before re-FinalizeTx-ing a tx that didn't
+// land where expected, growing with the attempt count and capped at
+// 30s so a persistently-rejected tx doesn't spin the reactor.
+func backoff(attempt int) time.Duration {
+	d := time.Duration(attempt) * 500 * time.Millisecond
+	if d > 30*time.Second {
+		return 30 * time.Second
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bytom/protocol/bc"
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+// TestScanForTxFindsIntermediateHeight covers the late-registration
+// race: by the time a slow caller registers, run() may have already
+// scanned several heights past where the caller started watching from,
+// and the tx can have landed anywhere in that gap, not just at the
+// caller's requested height.
+func TestScanForTxFindsIntermediateHeight(t *testing.T) {
+	target := bc.Hash{V0: 7}
+	other := bc.Hash{V0: 1}
+
+	blocks := map[uint64]*legacy.Block{
+		10: {Transactions: []*legacy.Tx{{ID: other}}},
+		11: {Transactions: []*legacy.Tx{{ID: other}}},
+		12: {Transactions: []*legacy.Tx{{ID: target}}},
+		13: {Transactions: []*legacy.Tx{{ID: other}}},
+	}
+	getBlock := func(h uint64) (*legacy.Block, error) {
+		return blocks[h], nil
+	}
+
+	h, ok := scanForTx(getBlock, 10, 13, target)
+	if !ok {
+		t.Fatal("scanForTx did not find a tx that landed in an intermediate height")
+	}
+	if h != 12 {
+		t.Fatalf("scanForTx returned height %d, want 12", h)
+	}
+}
+
+func TestScanForTxNotFound(t *testing.T) {
+	other := bc.Hash{V0: 1}
+	blocks := map[uint64]*legacy.Block{
+		10: {Transactions: []*legacy.Tx{{ID: other}}},
+	}
+	getBlock := func(h uint64) (*legacy.Block, error) {
+		return blocks[h], nil
+	}
+
+	if _, ok := scanForTx(getBlock, 10, 10, bc.Hash{V0: 99}); ok {
+		t.Fatal("scanForTx reported a match that isn't there")
+	}
+}
+
+func TestScanForTxSkipsUnreadableHeights(t *testing.T) {
+	errNotFound := errors.New("block not found")
+	target := bc.Hash{V0: 7}
+	blocks := map[uint64]*legacy.Block{
+		11: {Transactions: []*legacy.Tx{{ID: target}}},
+	}
+	getBlock := func(h uint64) (*legacy.Block, error) {
+		b, ok := blocks[h]
+		if !ok {
+			return nil, errNotFound
+		}
+		return b, nil
+	}
+
+	h, ok := scanForTx(getBlock, 10, 11, target)
+	if !ok || h != 11 {
+		t.Fatalf("scanForTx(10,11) = %d, %v; want 11, true", h, ok)
+	}
+}